@@ -0,0 +1,109 @@
+package sqinn
+
+import "time"
+
+// Backup copies the currently open database to destPath using SQLite's
+// online backup API, so callers can safely snapshot a live database
+// without stopping concurrent writers. destPath can be a filesystem path
+// or ":memory:"; backing up in either direction (file<->memory) is
+// supported, same as Open.
+//
+// The backup proceeds in batches of pagesPerStep pages, sleeping
+// sleepBetweenSteps between batches. sq is not locked while sleeping, so
+// other callers (e.g. a concurrent writer on the same instance) can run
+// in between batches instead of being starved for the whole backup.
+//
+// For further details, see https://www.sqlite.org/backup.html.
+func (sq *Sqinn) Backup(destPath string, pagesPerStep int, sleepBetweenSteps time.Duration) error {
+	if err := sq.backupInit(destPath); err != nil {
+		return err
+	}
+	for {
+		done, err := sq.backupStep(pagesPerStep)
+		if err != nil {
+			sq.backupFinish()
+			return err
+		}
+		if done {
+			return sq.backupFinish()
+		}
+		if sleepBetweenSteps > 0 {
+			time.Sleep(sleepBetweenSteps)
+		}
+	}
+}
+
+func (sq *Sqinn) backupInit(destPath string) error {
+	sq.mx.Lock()
+	defer sq.mx.Unlock()
+	// req
+	req := make([]byte, 0, 10+len(destPath))
+	req = append(req, fcBackupInit)
+	req = append(req, encodeString(destPath)...)
+	// resp
+	_, err := sq.writeAndRead(req)
+	return err
+}
+
+// backupStep runs one backup batch of up to pagesPerStep pages and
+// reports whether the backup is done.
+func (sq *Sqinn) backupStep(pagesPerStep int) (bool, error) {
+	sq.mx.Lock()
+	defer sq.mx.Unlock()
+	// req
+	req := make([]byte, 0, 5)
+	req = append(req, fcBackupStep)
+	req = append(req, encodeInt32(pagesPerStep)...)
+	// resp
+	resp, err := sq.writeAndRead(req)
+	if err != nil {
+		return false, err
+	}
+	done, _ := decodeBool(resp)
+	return done, nil
+}
+
+func (sq *Sqinn) backupFinish() error {
+	sq.mx.Lock()
+	defer sq.mx.Unlock()
+	// req
+	req := []byte{fcBackupFinish}
+	// resp
+	_, err := sq.writeAndRead(req)
+	return err
+}
+
+// CheckpointMode selects how Checkpoint truncates the write-ahead log,
+// mirroring SQLite's sqlite3_wal_checkpoint_v2 modes.
+type CheckpointMode byte
+
+const (
+	CheckpointPassive  CheckpointMode = 0
+	CheckpointFull     CheckpointMode = 1
+	CheckpointRestart  CheckpointMode = 2
+	CheckpointTruncate CheckpointMode = 3
+)
+
+// Checkpoint runs a WAL checkpoint in the given mode on the currently
+// open database, bounding how large its write-ahead log can grow. It
+// returns the number of pages in the log that could not be checkpointed
+// because of a conflicting reader or writer (busy), the total number of
+// pages currently in the log, and the number of pages that were written
+// back into the database file (checkpointed).
+//
+// For further details, see https://www.sqlite.org/c3ref/wal_checkpoint_v2.html.
+func (sq *Sqinn) Checkpoint(mode CheckpointMode) (busy, log, checkpointed int, err error) {
+	sq.mx.Lock()
+	defer sq.mx.Unlock()
+	// req
+	req := []byte{fcCheckpoint, byte(mode)}
+	// resp
+	resp, err := sq.writeAndRead(req)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	busy, resp = decodeInt32(resp)
+	log, resp = decodeInt32(resp)
+	checkpointed, resp = decodeInt32(resp)
+	return busy, log, checkpointed, nil
+}
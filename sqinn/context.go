@@ -0,0 +1,279 @@
+package sqinn
+
+import "context"
+
+// withContext runs fn, which performs a single blocking request to the
+// sqinn subprocess, and waits for either fn to finish or ctx to be done.
+//
+// fn is one of the self-locking Sqinn methods (Prepare, Step, ...), so it
+// may sit blocked on sq.mx for a while before its own request even
+// starts, if some other, unrelated caller is currently using this same
+// Sqinn instance (sq.mx serializes all callers of a single instance, see
+// Pool). If ctx is done before fn has had a chance to acquire sq.mx, no
+// request of ours is in flight yet, so withContext aborts nothing and
+// leaves that other call alone: it waits for fn to run to completion
+// once sq.mx is free, discards fn's result, and returns ctx.Err(). Only
+// once fn is known to
+// have acquired sq.mx - meaning the in-flight request, if any, is ours -
+// does a subsequent ctx.Done() abort it, by closing the pipes to sqinn
+// and killing the subprocess, which unblocks fn's pending writeAndRead.
+//
+// After a context deadline or cancellation aborts a request this way,
+// the Sqinn instance is poisoned: its subprocess is gone and every
+// subsequent call will fail. A poisoned instance should be discarded
+// (and, in a Pool, not returned to the idle set).
+func (sq *Sqinn) withContext(ctx context.Context, fn func() error) error {
+	if ctx == nil {
+		return fn()
+	}
+	locked := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		// Touch sq.mx ourselves first, purely to learn when fn (which
+		// locks it again right after) stops being queued behind another
+		// caller and starts actually running. See withContext's doc.
+		sq.mx.Lock()
+		close(locked)
+		sq.mx.Unlock()
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		select {
+		case <-locked:
+			sq.poison()
+		default:
+			// Still queued behind an unrelated in-flight call: nothing of
+			// ours to abort, so leave that call's instance alone.
+		}
+		<-done // wait for fn to return so it cannot write to sq concurrently with Terminate
+		return ctx.Err()
+	}
+}
+
+// poison aborts whatever request is currently in flight on sq by closing
+// its transport, which for the default StdioTransport also kills the
+// sqinn subprocess.
+func (sq *Sqinn) poison() {
+	sq.transport.Close()
+}
+
+// SqinnVersionContext is like SqinnVersion but aborts if ctx is done
+// before the request completes.
+func (sq *Sqinn) SqinnVersionContext(ctx context.Context, filename string) (string, error) {
+	var version string
+	err := sq.withContext(ctx, func() error {
+		var err error
+		version, err = sq.SqinnVersion(filename)
+		return err
+	})
+	return version, err
+}
+
+// IoVersionContext is like IoVersion but aborts if ctx is done before the
+// request completes.
+func (sq *Sqinn) IoVersionContext(ctx context.Context) (byte, error) {
+	var version byte
+	err := sq.withContext(ctx, func() error {
+		var err error
+		version, err = sq.IoVersion()
+		return err
+	})
+	return version, err
+}
+
+// SqliteVersionContext is like SqliteVersion but aborts if ctx is done
+// before the request completes.
+func (sq *Sqinn) SqliteVersionContext(ctx context.Context, filename string) (string, error) {
+	var version string
+	err := sq.withContext(ctx, func() error {
+		var err error
+		version, err = sq.SqliteVersion(filename)
+		return err
+	})
+	return version, err
+}
+
+// OpenContext is like Open but aborts if ctx is done before the request
+// completes. On abort, this Sqinn instance is poisoned, see withContext.
+func (sq *Sqinn) OpenContext(ctx context.Context, filename string) error {
+	return sq.withContext(ctx, func() error {
+		return sq.Open(filename)
+	})
+}
+
+// PrepareContext is like Prepare but aborts if ctx is done before the
+// request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) PrepareContext(ctx context.Context, sql string) error {
+	return sq.withContext(ctx, func() error {
+		return sq.Prepare(sql)
+	})
+}
+
+// BindContext is like Bind but aborts if ctx is done before the request
+// completes. On abort, this Sqinn instance is poisoned, see withContext.
+func (sq *Sqinn) BindContext(ctx context.Context, iparam int, value interface{}) error {
+	return sq.withContext(ctx, func() error {
+		return sq.Bind(iparam, value)
+	})
+}
+
+// StepContext is like Step but aborts if ctx is done before the request
+// completes. On abort, this Sqinn instance is poisoned, see withContext.
+func (sq *Sqinn) StepContext(ctx context.Context) (bool, error) {
+	var more bool
+	err := sq.withContext(ctx, func() error {
+		var err error
+		more, err = sq.Step()
+		return err
+	})
+	return more, err
+}
+
+// ResetContext is like Reset but aborts if ctx is done before the
+// request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) ResetContext(ctx context.Context) error {
+	return sq.withContext(ctx, func() error {
+		return sq.Reset()
+	})
+}
+
+// ChangesContext is like Changes but aborts if ctx is done before the
+// request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) ChangesContext(ctx context.Context) (int, error) {
+	var changes int
+	err := sq.withContext(ctx, func() error {
+		var err error
+		changes, err = sq.Changes()
+		return err
+	})
+	return changes, err
+}
+
+// ColumnContext is like Column but aborts if ctx is done before the
+// request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) ColumnContext(ctx context.Context, icol int, colType byte) (AnyValue, error) {
+	var any AnyValue
+	err := sq.withContext(ctx, func() error {
+		var err error
+		any, err = sq.Column(icol, colType)
+		return err
+	})
+	return any, err
+}
+
+// ColumnCountContext is like ColumnCount but aborts if ctx is done
+// before the request completes. On abort, this Sqinn instance is
+// poisoned, see withContext.
+func (sq *Sqinn) ColumnCountContext(ctx context.Context) (int, error) {
+	var count int
+	err := sq.withContext(ctx, func() error {
+		var err error
+		count, err = sq.ColumnCount()
+		return err
+	})
+	return count, err
+}
+
+// ColumnNameContext is like ColumnName but aborts if ctx is done before
+// the request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) ColumnNameContext(ctx context.Context, icol int) (string, error) {
+	var name string
+	err := sq.withContext(ctx, func() error {
+		var err error
+		name, err = sq.ColumnName(icol)
+		return err
+	})
+	return name, err
+}
+
+// ColumnTypeContext is like ColumnType but aborts if ctx is done before
+// the request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) ColumnTypeContext(ctx context.Context, icol int) (byte, error) {
+	var colType byte
+	err := sq.withContext(ctx, func() error {
+		var err error
+		colType, err = sq.ColumnType(icol)
+		return err
+	})
+	return colType, err
+}
+
+// FinalizeContext is like Finalize but aborts if ctx is done before the
+// request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) FinalizeContext(ctx context.Context) error {
+	return sq.withContext(ctx, func() error {
+		return sq.Finalize()
+	})
+}
+
+// CloseContext is like Close but aborts if ctx is done before the
+// request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) CloseContext(ctx context.Context) error {
+	return sq.withContext(ctx, func() error {
+		return sq.Close()
+	})
+}
+
+// ExecOneContext is like ExecOne but aborts if ctx is done before the
+// request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) ExecOneContext(ctx context.Context, sql string) (int, error) {
+	var changes int
+	err := sq.withContext(ctx, func() error {
+		var err error
+		changes, err = sq.ExecOne(sql)
+		return err
+	})
+	return changes, err
+}
+
+// ExecContext is like Exec but aborts if ctx is done before the request
+// completes. On abort, this Sqinn instance is poisoned, see withContext.
+func (sq *Sqinn) ExecContext(ctx context.Context, sql string, niterations, nparams int, values []interface{}) ([]int, error) {
+	var changes []int
+	err := sq.withContext(ctx, func() error {
+		var err error
+		changes, err = sq.Exec(sql, niterations, nparams, values)
+		return err
+	})
+	return changes, err
+}
+
+// QueryContext is like Query but aborts if ctx is done before the
+// request completes. On abort, this Sqinn instance is poisoned, see
+// withContext.
+func (sq *Sqinn) QueryContext(ctx context.Context, sql string, values []interface{}, colTypes []byte) ([]Row, error) {
+	var rows []Row
+	err := sq.withContext(ctx, func() error {
+		var err error
+		rows, err = sq.Query(sql, values, colTypes)
+		return err
+	})
+	return rows, err
+}
+
+// QueryIterContext is like QueryIter but aborts if ctx is done before
+// the request to prepare and bind the statement completes. Once a Rows
+// is returned, subsequent calls to Rows.Next are not governed by ctx;
+// callers who need per-row cancellation should derive the colTypes loop
+// themselves using StepContext/ColumnContext.
+func (sq *Sqinn) QueryIterContext(ctx context.Context, sql string, values []interface{}, colTypes []byte) (*Rows, error) {
+	var rows *Rows
+	err := sq.withContext(ctx, func() error {
+		var err error
+		rows, err = sq.QueryIter(sql, values, colTypes)
+		return err
+	})
+	return rows, err
+}
@@ -0,0 +1,63 @@
+package sqinn
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// fakeTransport is a Transport that answers every non-empty request with
+// a generic successful response, without spawning a sqinn subprocess.
+// It exists to let Pool and Sqinn be tested in isolation, now that
+// Transport makes that possible (see transport.go).
+type fakeTransport struct {
+	mx     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newFakeTransport() *fakeTransport {
+	t := &fakeTransport{}
+	t.cond = sync.NewCond(&t.mx)
+	return t
+}
+
+func (t *fakeTransport) Write(p []byte) (int, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.closed {
+		return 0, io.ErrClosedPipe
+	}
+	sz, _ := decodeInt32(p)
+	if sz <= 0 {
+		// the zero-length "please exit" frame Terminate sends; nothing to
+		// respond with.
+		return len(p), nil
+	}
+	resp := append([]byte{frameResponse}, encodeBool(true)...)
+	t.buf.Write(encodeInt32(len(resp)))
+	t.buf.Write(resp)
+	t.cond.Broadcast()
+	return len(p), nil
+}
+
+func (t *fakeTransport) Read(p []byte) (int, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for t.buf.Len() == 0 && !t.closed {
+		t.cond.Wait()
+	}
+	if t.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return t.buf.Read(p)
+}
+
+func (t *fakeTransport) Close() error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.closed = true
+	t.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,213 @@
+package sqinn
+
+import "fmt"
+
+// aggregateFunc holds the two callbacks an aggregate SQL function is
+// built from, see RegisterAggregate.
+type aggregateFunc struct {
+	step  func(state AnyValue, args []AnyValue) (AnyValue, error)
+	final func(state AnyValue) (AnyValue, error)
+}
+
+// callback kinds, identifying what a frameCallback frame is asking for.
+const (
+	callbackScalar   byte = 0
+	callbackAggStep  byte = 1
+	callbackAggFinal byte = 2
+)
+
+// RegisterFunc registers a scalar SQL function named name, taking nArg
+// arguments (or a variable number of arguments if nArg is -1), so it can
+// be called from any SQL run on this Sqinn instance afterwards. fn is
+// invoked synchronously, on the same goroutine that is blocked inside
+// whichever Exec/Query/Step/... call made sqlite evaluate the call to
+// name; it must not itself call any method on sq, since sq allows only
+// one request in flight at a time.
+//
+// For further details, see https://www.sqlite.org/c3ref/create_function.html.
+func (sq *Sqinn) RegisterFunc(name string, nArg int, fn func(args []AnyValue) (AnyValue, error)) error {
+	sq.mx.Lock()
+	defer sq.mx.Unlock()
+	if sq.funcs == nil {
+		sq.funcs = make(map[int]func([]AnyValue) (AnyValue, error))
+	}
+	id := sq.nextFuncID
+	sq.nextFuncID++
+	sq.funcs[id] = fn
+	// req
+	req := make([]byte, 0, 14+len(name))
+	req = append(req, fcRegisterFunc)
+	req = append(req, encodeInt32(id)...)
+	req = append(req, encodeString(name)...)
+	req = append(req, encodeInt32(nArg)...)
+	req = append(req, encodeBool(false)...) // isAggregate
+	// resp
+	_, err := sq.writeAndRead(req)
+	if err != nil {
+		delete(sq.funcs, id)
+		return err
+	}
+	return nil
+}
+
+// RegisterAggregate registers an aggregate SQL function named name,
+// taking nArg arguments. step is called once per row, with the
+// accumulated state (a zero AnyValue on the first row of each group)
+// and that row's arguments, and returns the new accumulated state. final
+// is called once after the last row of a group to turn the accumulated
+// state into the aggregate's result.
+//
+// For further details, see https://www.sqlite.org/c3ref/create_function.html.
+func (sq *Sqinn) RegisterAggregate(name string, nArg int, step func(state AnyValue, args []AnyValue) (AnyValue, error), final func(state AnyValue) (AnyValue, error)) error {
+	sq.mx.Lock()
+	defer sq.mx.Unlock()
+	if sq.aggregates == nil {
+		sq.aggregates = make(map[int]aggregateFunc)
+	}
+	id := sq.nextFuncID
+	sq.nextFuncID++
+	sq.aggregates[id] = aggregateFunc{step: step, final: final}
+	// req
+	req := make([]byte, 0, 14+len(name))
+	req = append(req, fcRegisterFunc)
+	req = append(req, encodeInt32(id)...)
+	req = append(req, encodeString(name)...)
+	req = append(req, encodeInt32(nArg)...)
+	req = append(req, encodeBool(true)...) // isAggregate
+	// resp
+	_, err := sq.writeAndRead(req)
+	if err != nil {
+		delete(sq.aggregates, id)
+		return err
+	}
+	return nil
+}
+
+// handleCallback decodes a frameCallback frame (written in place of the
+// final response while a registered Go function is being evaluated
+// mid-statement), dispatches it to the matching registered function, and
+// writes the result back as a new frame so sqinn can resume. It is only
+// ever called from within writeAndRead's read loop, so sq.mx is already
+// held and body is the frame payload with the frame-kind byte already
+// stripped.
+func (sq *Sqinn) handleCallback(body []byte) {
+	var id int
+	id, body = decodeInt32(body)
+	var kind byte
+	kind, body = decodeByte(body)
+	var ctxID int
+	ctxID, body = decodeInt32(body)
+	var nargs int
+	nargs, body = decodeInt32(body)
+	args := make([]AnyValue, nargs)
+	for i := range args {
+		args[i], body = decodeTaggedValue(body)
+	}
+	var result AnyValue
+	var err error
+	switch kind {
+	case callbackScalar:
+		fn, ok := sq.funcs[id]
+		if !ok {
+			err = fmt.Errorf("sqinn: no function registered with id %d", id)
+			break
+		}
+		result, err = fn(args)
+	case callbackAggStep:
+		agg, ok := sq.aggregates[id]
+		if !ok {
+			err = fmt.Errorf("sqinn: no aggregate registered with id %d", id)
+			break
+		}
+		if sq.aggState == nil {
+			sq.aggState = make(map[int]AnyValue)
+		}
+		result, err = agg.step(sq.aggState[ctxID], args)
+		if err == nil {
+			sq.aggState[ctxID] = result
+		}
+	case callbackAggFinal:
+		agg, ok := sq.aggregates[id]
+		if !ok {
+			err = fmt.Errorf("sqinn: no aggregate registered with id %d", id)
+			break
+		}
+		result, err = agg.final(sq.aggState[ctxID])
+		delete(sq.aggState, ctxID)
+	default:
+		err = fmt.Errorf("sqinn: unknown callback kind %d", kind)
+	}
+	sq.writeCallbackReply(result, err)
+}
+
+func (sq *Sqinn) writeCallbackReply(result AnyValue, err error) {
+	var resp []byte
+	if err != nil {
+		resp = append(resp, encodeBool(false)...)
+		resp = append(resp, encodeString(err.Error())...)
+	} else {
+		resp = append(resp, encodeBool(true)...)
+		resp = append(resp, encodeTaggedValue(result)...)
+	}
+	frame := make([]byte, 0, 4+len(resp))
+	frame = append(frame, encodeInt32(len(resp))...)
+	frame = append(frame, resp...)
+	// Best effort: if this write fails the pending writeAndRead's own
+	// read will fail right after with the same underlying transport
+	// error, so there is no separate error path to report through here.
+	sq.transport.Write(frame)
+}
+
+// decodeTaggedValue decodes a value that is prefixed by its own
+// ValXxx type tag, as used for callback arguments, whose types are not
+// known ahead of time the way Query's colTypes are.
+func decodeTaggedValue(data []byte) (AnyValue, []byte) {
+	var any AnyValue
+	var tag byte
+	tag, data = decodeByte(data)
+	switch tag {
+	case ValNull:
+		// any stays zero, meaning NULL
+	case ValInt:
+		any.Int.Set = true
+		any.Int.Value, data = decodeInt32(data)
+	case ValInt64:
+		any.Int64.Set = true
+		any.Int64.Value, data = decodeInt64(data)
+	case ValDouble:
+		any.Double.Set = true
+		any.Double.Value, data = decodeDouble(data)
+	case ValText:
+		any.String.Set = true
+		any.String.Value, data = decodeString(data)
+	case ValBlob:
+		any.Blob.Set = true
+		any.Blob.Value, data = decodeBlob(data)
+	}
+	return any, data
+}
+
+// encodeTaggedValue is the inverse of decodeTaggedValue: it encodes v
+// prefixed by the ValXxx tag of whichever of its fields is set, or
+// ValNull if none is.
+func encodeTaggedValue(v AnyValue) []byte {
+	switch {
+	case v.Int.Set:
+		buf := []byte{ValInt}
+		return append(buf, encodeInt32(v.Int.Value)...)
+	case v.Int64.Set:
+		buf := []byte{ValInt64}
+		return append(buf, encodeInt64(v.Int64.Value)...)
+	case v.Double.Set:
+		buf := []byte{ValDouble}
+		return append(buf, encodeDouble(v.Double.Value)...)
+	case v.String.Set:
+		buf := []byte{ValText}
+		return append(buf, encodeString(v.String.Value)...)
+	case v.Blob.Set:
+		buf := []byte{ValBlob}
+		return append(buf, encodeBlob(v.Blob.Value)...)
+	default:
+		return []byte{ValNull}
+	}
+}
@@ -0,0 +1,329 @@
+package sqinn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PoolOptions configure a Pool.
+type PoolOptions struct {
+
+	// Filename is the database opened on every pooled Sqinn instance,
+	// see Sqinn.Open.
+	Filename string
+
+	// SqinnOptions are passed to New for every pooled Sqinn instance.
+	SqinnOptions Options
+
+	// MinSize is the number of Sqinn instances started eagerly by
+	// NewPool, and the number kept around even when idle. Default is 1.
+	MinSize int
+
+	// MaxSize is the maximum number of Sqinn instances the Pool will
+	// start. If MaxSize is less than MinSize, MinSize is used instead.
+	// Default is 1.
+	MaxSize int
+
+	// IdleTimeout is how long an instance beyond MinSize may sit idle
+	// in the pool before it is terminated. Zero disables idle eviction.
+	IdleTimeout time.Duration
+}
+
+// Pool manages a set of independent Sqinn instances, each running its
+// own sqinn subprocess with its own open database. Since sqinn allows
+// only one active prepared statement per instance, the sync.Mutex in
+// Sqinn serializes all callers of a single instance; Pool instead hands
+// out exclusive use of one whole instance at a time via Acquire/Do, so
+// independent callers can run statements concurrently.
+//
+// Write transactions must not be spread across multiple pooled
+// instances against the same database file, or SQLite will return BUSY
+// when more than one connection tries to write at once. Use DoWrite,
+// which always runs against the same dedicated instance, for those.
+type Pool struct {
+	options PoolOptions
+
+	mx      sync.Mutex
+	idle    []*pooledSqinn
+	numOpen int
+	waiters []chan *pooledSqinn
+	closed  bool
+
+	writerMx sync.Mutex
+	writer   *Sqinn
+}
+
+type pooledSqinn struct {
+	sq       *Sqinn
+	lastUsed time.Time
+}
+
+// NewPool creates a Pool and eagerly starts options.MinSize Sqinn
+// instances, each with options.Filename opened. If any of them fails to
+// start, the already-started instances are terminated and the error is
+// returned.
+func NewPool(options PoolOptions) (*Pool, error) {
+	if options.MinSize < 1 {
+		options.MinSize = 1
+	}
+	if options.MaxSize < options.MinSize {
+		options.MaxSize = options.MinSize
+	}
+	p := &Pool{options: options}
+	for i := 0; i < options.MinSize; i++ {
+		sq, err := p.open(context.Background())
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.idle = append(p.idle, &pooledSqinn{sq: sq, lastUsed: time.Now()})
+		p.numOpen++
+	}
+	if options.IdleTimeout > 0 {
+		go p.evictIdle()
+	}
+	return p, nil
+}
+
+// open starts a new Sqinn instance and opens p.options.Filename on it,
+// aborting early with ctx.Err() if ctx is done first, so a hung sqinn
+// subprocess cannot make Acquire (or DoWrite's lazy writer startup)
+// uncancellable as documented. If ctx fires while the subprocess is
+// still starting up, the start is left to finish in the background and
+// the resulting instance is terminated immediately, so it is not leaked.
+func (p *Pool) open(ctx context.Context) (*Sqinn, error) {
+	type result struct {
+		sq  *Sqinn
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sq, err := New(p.options.SqinnOptions)
+		if err != nil {
+			done <- result{nil, err}
+			return
+		}
+		if err := sq.OpenContext(ctx, p.options.Filename); err != nil {
+			sq.Terminate()
+			done <- result{nil, err}
+			return
+		}
+		done <- result{sq, nil}
+	}()
+	select {
+	case r := <-done:
+		return r.sq, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.sq != nil {
+				r.sq.Terminate()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Acquire waits for an idle Sqinn instance, starting a new one if the
+// pool has not yet reached MaxSize, and returns it along with a release
+// function that must be called exactly once to return the instance to
+// the pool. Acquire returns ctx.Err() if ctx is done before an instance
+// becomes available.
+func (p *Pool) Acquire(ctx context.Context) (*Sqinn, func(), error) {
+	p.mx.Lock()
+	if p.closed {
+		p.mx.Unlock()
+		return nil, nil, fmt.Errorf("sqinn: pool is closed")
+	}
+	if n := len(p.idle); n > 0 {
+		ps := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mx.Unlock()
+		return ps.sq, p.releaseFunc(ps.sq), nil
+	}
+	if p.numOpen < p.options.MaxSize {
+		p.numOpen++
+		p.mx.Unlock()
+		sq, err := p.open(ctx)
+		if err != nil {
+			p.mx.Lock()
+			p.numOpen--
+			p.mx.Unlock()
+			return nil, nil, err
+		}
+		return sq, p.releaseFunc(sq), nil
+	}
+	waiter := make(chan *pooledSqinn, 1)
+	p.waiters = append(p.waiters, waiter)
+	p.mx.Unlock()
+	select {
+	case ps, ok := <-waiter:
+		if !ok || ps == nil {
+			return nil, nil, fmt.Errorf("sqinn: pool is closed")
+		}
+		return ps.sq, p.releaseFunc(ps.sq), nil
+	case <-ctx.Done():
+		p.mx.Lock()
+		stillWaiting := p.removeWaiter(waiter)
+		p.mx.Unlock()
+		if !stillWaiting {
+			// release() already popped this waiter (atomically with
+			// handing it an instance, see release) before we could
+			// remove it: the instance is sitting in waiter, already
+			// sent, waiting for nobody. Drain it and hand it back to
+			// the pool instead of leaking it.
+			if ps := <-waiter; ps != nil {
+				p.release(ps.sq)
+			}
+		}
+		return nil, nil, ctx.Err()
+	}
+}
+
+// removeWaiter removes waiter from p.waiters if it is still there and
+// reports whether it found (and removed) it.
+func (p *Pool) removeWaiter(waiter chan *pooledSqinn) bool {
+	for i, w := range p.waiters {
+		if w == waiter {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) releaseFunc(sq *Sqinn) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.release(sq)
+		})
+	}
+}
+
+func (p *Pool) release(sq *Sqinn) {
+	ps := &pooledSqinn{sq: sq, lastUsed: time.Now()}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.closed {
+		sq.Terminate()
+		return
+	}
+	if len(p.waiters) > 0 {
+		waiter := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		// waiter is buffered (capacity 1) and, once popped here, is
+		// only ever handed to this one instance, so this send cannot
+		// block; doing it while still holding p.mx is what lets
+		// Acquire's ctx.Done path tell, atomically, whether it raced
+		// with this hand-off (see removeWaiter).
+		waiter <- ps
+		return
+	}
+	p.idle = append(p.idle, ps)
+}
+
+// Do acquires an instance, passes it to fn, and releases it again once
+// fn returns, regardless of error.
+func (p *Pool) Do(ctx context.Context, fn func(*Sqinn) error) error {
+	sq, release, err := p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(sq)
+}
+
+// DoWrite runs fn against a single dedicated Sqinn instance, started
+// lazily on first use, instead of one drawn from the general pool. Use
+// it for write transactions: SQLite allows only one writer at a time per
+// database file, so spreading BEGIN/COMMIT across multiple pooled
+// connections would make concurrent writers fail with SQLITE_BUSY.
+func (p *Pool) DoWrite(ctx context.Context, fn func(*Sqinn) error) error {
+	p.writerMx.Lock()
+	defer p.writerMx.Unlock()
+	if p.writer == nil {
+		sq, err := p.open(ctx)
+		if err != nil {
+			return err
+		}
+		p.writer = sq
+	}
+	return fn(p.writer)
+}
+
+// HealthCheck calls IoVersion on every idle instance and removes (and
+// terminates) any that fail to respond, e.g. because the sqinn
+// subprocess died. It does not touch instances currently acquired or the
+// dedicated write connection.
+func (p *Pool) HealthCheck() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	alive := p.idle[:0]
+	for _, ps := range p.idle {
+		if _, err := ps.sq.IoVersion(); err != nil {
+			ps.sq.Terminate()
+			p.numOpen--
+			continue
+		}
+		alive = append(alive, ps)
+	}
+	p.idle = alive
+}
+
+func (p *Pool) evictIdle() {
+	ticker := time.NewTicker(p.options.IdleTimeout)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mx.Lock()
+		if p.closed {
+			p.mx.Unlock()
+			return
+		}
+		cutoff := time.Now().Add(-p.options.IdleTimeout)
+		kept := p.idle[:0]
+		for _, ps := range p.idle {
+			if p.numOpen > p.options.MinSize && ps.lastUsed.Before(cutoff) {
+				ps.sq.Terminate()
+				p.numOpen--
+				continue
+			}
+			kept = append(kept, ps)
+		}
+		p.idle = kept
+		p.mx.Unlock()
+	}
+}
+
+// Close terminates every idle Sqinn instance owned by the pool, as well
+// as the dedicated write connection. Instances that are currently
+// acquired are terminated as soon as they are released. Close must only
+// be called once.
+func (p *Pool) Close() error {
+	p.mx.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	waiters := p.waiters
+	p.waiters = nil
+	p.mx.Unlock()
+	for _, waiter := range waiters {
+		close(waiter)
+	}
+	var firstErr error
+	for _, ps := range idle {
+		if err := ps.sq.Terminate(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	p.writerMx.Lock()
+	if p.writer != nil {
+		if err := p.writer.Terminate(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		p.writer = nil
+	}
+	p.writerMx.Unlock()
+	return firstErr
+}
@@ -0,0 +1,56 @@
+package sqinn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestPoolAcquireCtxCancelDoesNotLeakCapacity covers the race fixed
+// alongside this test: Acquire's ctx firing while queued as a waiter
+// must not leave that waiter registered, or a later release would hand
+// the released instance to it and lose it for good (see removeWaiter).
+func TestPoolAcquireCtxCancelDoesNotLeakCapacity(t *testing.T) {
+	pool, err := NewPool(PoolOptions{
+		MinSize:      1,
+		MaxSize:      1,
+		SqinnOptions: Options{Transport: newFakeTransport()},
+	})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer pool.Close()
+
+	_, release, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	// The pool is now at MaxSize with its one instance checked out, so
+	// this Acquire queues as a waiter and only ever hears back from
+	// ctx.Done, since it was already canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := pool.Acquire(ctx); err != ctx.Err() {
+		t.Fatalf("Acquire with canceled ctx: got err %v, want %v", err, ctx.Err())
+	}
+
+	release()
+
+	done := make(chan error, 1)
+	go func() {
+		_, release2, err := pool.Acquire(context.Background())
+		if err == nil {
+			release2()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Acquire after release: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("released instance was never handed back to the pool (leaked to the abandoned waiter)")
+	}
+}
@@ -0,0 +1,170 @@
+package sqinn
+
+import "fmt"
+
+// Rows is a streaming iterator over the results of a query started with
+// QueryIter. Unlike Query, which loads the whole result set into memory
+// at once, Rows fetches and decodes one row at a time, so arbitrarily
+// large SELECTs can be processed without risking an out-of-memory
+// condition.
+//
+// A Rows must be closed with Close once the caller is done with it,
+// whether or not iteration ran to completion, since it holds sqinn's one
+// allowed active prepared statement.
+type Rows struct {
+	sq       *Sqinn
+	colTypes []byte
+	row      []AnyValue
+	err      error
+	done     bool
+	closed   bool
+}
+
+// QueryIter prepares sql, binds values, and returns a Rows iterator over
+// the result. colTypes holds the expected type of each column the query
+// yields, with the same meaning as in Query.
+//
+// QueryIter is built on the low-level Prepare/Bind/Step/Column/Finalize
+// primitives rather than the batch fcQuery opcode that Query uses, so
+// results are streamed from sqinn row by row instead of being buffered
+// up front.
+func (sq *Sqinn) QueryIter(sql string, values []interface{}, colTypes []byte) (*Rows, error) {
+	if err := sq.Prepare(sql); err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if err := sq.Bind(i+1, value); err != nil {
+			sq.Finalize()
+			return nil, err
+		}
+	}
+	return &Rows{sq: sq, colTypes: colTypes}, nil
+}
+
+// Next advances to the next row and reports whether one is available.
+// Once Next returns false, the caller should check Err to distinguish
+// end-of-results from an error, and then call Close.
+func (r *Rows) Next() bool {
+	if r.done {
+		return false
+	}
+	more, err := r.sq.Step()
+	if err != nil {
+		r.err = err
+		r.done = true
+		return false
+	}
+	if !more {
+		r.done = true
+		return false
+	}
+	row := make([]AnyValue, len(r.colTypes))
+	for icol, colType := range r.colTypes {
+		any, err := r.sq.Column(icol, colType)
+		if err != nil {
+			r.err = err
+			r.done = true
+			return false
+		}
+		row[icol] = any
+	}
+	r.row = row
+	return true
+}
+
+// Scan copies the values of the current row into dest, in column order.
+// Each dest element must be a pointer to one of *int, *int64, *float64,
+// *string, *[]byte or *interface{}. For a NULL column, Scan sets an
+// *interface{} destination to nil and leaves other destination types
+// unchanged.
+//
+// Scan must only be called after a call to Next that returned true.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.row == nil {
+		return fmt.Errorf("sqinn: Scan called without a successful call to Next")
+	}
+	if len(dest) != len(r.row) {
+		return fmt.Errorf("sqinn: Scan expected %d destinations but got %d", len(r.row), len(dest))
+	}
+	for icol, any := range r.row {
+		if err := scanAnyValue(any, r.colTypes[icol], dest[icol]); err != nil {
+			return fmt.Errorf("sqinn: Scan column %d: %w", icol, err)
+		}
+	}
+	return nil
+}
+
+// Err returns the first error encountered while iterating, if any.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close finalizes the underlying prepared statement. Close may be called
+// multiple times; only the first call has an effect.
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.sq.Finalize()
+}
+
+func scanAnyValue(any AnyValue, colType byte, dest interface{}) error {
+	switch d := dest.(type) {
+	case *interface{}:
+		*d = anyValueToInterface(any, colType)
+	case *int:
+		if any.Int.Set {
+			*d = any.Int.Value
+		} else if any.Int64.Set {
+			*d = int(any.Int64.Value)
+		}
+	case *int64:
+		if any.Int64.Set {
+			*d = any.Int64.Value
+		} else if any.Int.Set {
+			*d = int64(any.Int.Value)
+		}
+	case *float64:
+		if any.Double.Set {
+			*d = any.Double.Value
+		}
+	case *string:
+		if any.String.Set {
+			*d = any.String.Value
+		}
+	case *[]byte:
+		if any.Blob.Set {
+			*d = any.Blob.Value
+		}
+	default:
+		return fmt.Errorf("unsupported scan destination type %T", dest)
+	}
+	return nil
+}
+
+func anyValueToInterface(any AnyValue, colType byte) interface{} {
+	switch colType {
+	case ValInt:
+		if any.Int.Set {
+			return any.Int.Value
+		}
+	case ValInt64:
+		if any.Int64.Set {
+			return any.Int64.Value
+		}
+	case ValDouble:
+		if any.Double.Set {
+			return any.Double.Value
+		}
+	case ValText:
+		if any.String.Set {
+			return any.String.Value
+		}
+	case ValBlob:
+		if any.Blob.Set {
+			return any.Blob.Value
+		}
+	}
+	return nil
+}
@@ -1,12 +1,11 @@
 package sqinn
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"log"
-	"os/exec"
 	"sync"
+	"time"
 )
 
 // function codes, see sqinn/src/handler.h
@@ -24,10 +23,27 @@ const (
 	fcColumn        byte = 16
 	fcFinalize      byte = 17
 	fcClose         byte = 18
+	fcColumnCount   byte = 19
+	fcColumnName    byte = 20
+	fcColumnType    byte = 21
+	fcRegisterFunc  byte = 22
+	fcBackupInit    byte = 23
+	fcBackupStep    byte = 24
+	fcBackupFinish  byte = 25
+	fcCheckpoint    byte = 26
 	fcExec          byte = 51
 	fcQuery         byte = 52
 )
 
+// frame kinds. Every frame sqinn writes back is now prefixed by one of
+// these, so the read loop in writeAndRead can tell an in-band callback
+// invocation (see RegisterFunc) apart from the final response to the
+// request that is currently in flight.
+const (
+	frameResponse byte = 0
+	frameCallback byte = 1
+)
+
 // Options for launching a Sqinn instance.
 type Options struct {
 
@@ -38,69 +54,48 @@ type Options struct {
 	// Logger logs the debug and error messages that the sinn subprocess will output
 	// on its stderr. Default is nil, which does not log anything.
 	Logger Logger
+
+	// Transport overrides how Sqinn exchanges requests and responses
+	// with a running sqinn. If nil (the default), New launches a local
+	// sqinn subprocess (see SqinnPath) and talks to it over stdio via
+	// StdioTransport. Set Transport to a NetTransport to talk to an
+	// already-running sqinn daemon over TCP or a Unix domain socket
+	// instead; in that case SqinnPath is ignored.
+	Transport Transport
 }
 
 // Sqinn is a running sqinn instance.
 type Sqinn struct {
-	mx   sync.Mutex
-	cmd  *exec.Cmd
-	sin  io.WriteCloser
-	sout io.ReadCloser
-	serr io.ReadCloser
+	mx         sync.Mutex
+	transport  Transport
+	nextFuncID int
+	funcs      map[int]func([]AnyValue) (AnyValue, error)
+	aggregates map[int]aggregateFunc
+	aggState   map[int]AnyValue
 }
 
 /*
 New launches a new Sqinn instance. The options argument specifies
 the path to the sqinn executable. Moreover, it specifies how Sqinn's
 stderr log outputs should be logged.
+
+If options.Transport is set, New uses it instead of launching a local
+subprocess; SqinnPath and Logger are then ignored.
 */
 func New(options Options) (*Sqinn, error) {
-	sqinnPath := options.SqinnPath
-	if sqinnPath == "" {
-		sqinnPath = "sqinn"
-	}
-	cmd := exec.Command(sqinnPath)
-	sin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-	sout, err := cmd.StdoutPipe()
-	if err != nil {
-		sin.Close()
-		return nil, err
-	}
-	serr, err := cmd.StderrPipe()
-	if err != nil {
-		sout.Close()
-		sin.Close()
-		return nil, err
-	}
-	err = cmd.Start()
-	if err != nil {
-		serr.Close()
-		sout.Close()
-		sin.Close()
-		return nil, err
-	}
-	sq := &Sqinn{sync.Mutex{}, cmd, sin, sout, serr}
-	logger := options.Logger
-	if logger == nil {
-		logger = NoLogger{}
-	}
-	go sq.run(logger)
-	return sq, nil
-}
-
-func (sq *Sqinn) run(logger Logger) {
-	sc := bufio.NewScanner(sq.serr)
-	for sc.Scan() {
-		text := sc.Text()
-		logger.Log(fmt.Sprintf("[sqinn] %s", text))
-	}
-	err := sc.Err()
-	if err != nil {
-		logger.Log(fmt.Sprintf("[sqinn] stderr: %s", err))
+	transport := options.Transport
+	if transport == nil {
+		logger := options.Logger
+		if logger == nil {
+			logger = NoLogger{}
+		}
+		var err error
+		transport, err = NewStdioTransport(options.SqinnPath, logger)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return &Sqinn{transport: transport}, nil
 }
 
 // SqinnVersion returns the version of the Sqinn executable.
@@ -381,6 +376,78 @@ func (sq *Sqinn) Column(icol int, colType byte) (AnyValue, error) {
 	return any, err
 }
 
+// ColumnCount returns the number of columns produced by the currently
+// prepared statement. It is zero for statements that do not return rows.
+//
+// This is a low-level function. Most users will use Exec/Query instead.
+//
+// For further details, see https://www.sqlite.org/c3ref/column_count.html.
+func (sq *Sqinn) ColumnCount() (int, error) {
+	sq.mx.Lock()
+	defer sq.mx.Unlock()
+	// req
+	req := []byte{fcColumnCount}
+	// resp
+	resp, err := sq.writeAndRead(req)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	count, resp = decodeInt32(resp)
+	return count, nil
+}
+
+// ColumnName returns the name of the icol'th column of the currently
+// prepared statement. Note that icol starts at 0 (not 1).
+//
+// This is a low-level function. Most users will use Exec/Query instead.
+//
+// For further details, see https://www.sqlite.org/c3ref/column_name.html.
+func (sq *Sqinn) ColumnName(icol int) (string, error) {
+	sq.mx.Lock()
+	defer sq.mx.Unlock()
+	// req
+	req := make([]byte, 0, 5)
+	req = append(req, fcColumnName)
+	req = append(req, encodeInt32(icol)...)
+	// resp
+	resp, err := sq.writeAndRead(req)
+	if err != nil {
+		return "", err
+	}
+	var name string
+	name, resp = decodeString(resp)
+	return name, nil
+}
+
+// ColumnType returns the storage class of the icol'th column of the
+// current row: one of ValNull, ValInt, ValInt64, ValDouble, ValText or
+// ValBlob. Unlike Column, ColumnType does not require the caller to
+// already know the column's type; it exists for callers, such as the
+// database/sql driver in sqinndriver, that discover column types at
+// runtime rather than from a fixed schema. Note that icol starts at 0
+// (not 1).
+//
+// This is a low-level function. Most users will use Exec/Query instead.
+//
+// For further details, see https://www.sqlite.org/c3ref/column_blob.html.
+func (sq *Sqinn) ColumnType(icol int) (byte, error) {
+	sq.mx.Lock()
+	defer sq.mx.Unlock()
+	// req
+	req := make([]byte, 0, 5)
+	req = append(req, fcColumnType)
+	req = append(req, encodeInt32(icol)...)
+	// resp
+	resp, err := sq.writeAndRead(req)
+	if err != nil {
+		return 0, err
+	}
+	var colType byte
+	colType, _ = decodeByte(resp)
+	return colType, nil
+}
+
 // Finalize finalizes a statement that has been prepared with Prepare.
 // To avoid memory leaks, each statement has to be finalized.
 // Moreover, since Sqinn allows only one statement at a time,
@@ -583,51 +650,65 @@ func (sq *Sqinn) writeAndRead(req []byte) ([]byte, error) {
 	if traceReq {
 		log.Printf("write %d bytes sz+req: %v", len(buf), buf)
 	}
-	_, err := sq.sin.Write(buf)
+	_, err := sq.transport.Write(buf)
 	if err != nil {
 		return nil, err
 	}
-	// read resp
-	if traceResp {
-		// time.Sleep(100 * time.Millisecond)
-		log.Printf("waiting for 4 bytes resp sz")
-	}
-	buf = make([]byte, 4)
-	_, err = io.ReadFull(sq.sout, buf)
-	if err != nil {
-		return nil, fmt.Errorf("while reading from sqinn: %w", err)
-	}
-	if traceResp {
-		log.Printf("received %d bytes resp length: %v", len(buf), buf)
-	}
-	sz, _ = decodeInt32(buf)
-	if traceResp {
-		log.Printf("resp length will be %d bytes", sz)
-	}
-	if sz <= 0 {
-		return nil, fmt.Errorf("invalid response size %d", sz)
-	}
-	buf = make([]byte, sz)
-	if traceResp {
-		log.Printf("waiting for %d resp data", sz)
-	}
-	_, err = io.ReadFull(sq.sout, buf)
-	if err != nil {
-		return nil, fmt.Errorf("while reading from sqinn: %w", err)
-	}
-	if traceResp {
-		log.Printf("received %d bytes resp data: %v", len(buf), buf)
-		// time.Sleep(100 * time.Millisecond)
-	}
-	var ok bool
-	ok, buf = decodeBool(buf)
-	if !ok {
-		msg, _ := decodeString(buf)
-		return nil, fmt.Errorf("sqinn: %s", msg)
+	// read resp, looping past any callback frames (see RegisterFunc)
+	// until the final response to req arrives.
+	for {
+		if traceResp {
+			// time.Sleep(100 * time.Millisecond)
+			log.Printf("waiting for 4 bytes resp sz")
+		}
+		buf = make([]byte, 4)
+		_, err = io.ReadFull(sq.transport, buf)
+		if err != nil {
+			return nil, fmt.Errorf("while reading from sqinn: %w", err)
+		}
+		if traceResp {
+			log.Printf("received %d bytes resp length: %v", len(buf), buf)
+		}
+		sz, _ = decodeInt32(buf)
+		if traceResp {
+			log.Printf("resp length will be %d bytes", sz)
+		}
+		if sz <= 0 {
+			return nil, fmt.Errorf("invalid response size %d", sz)
+		}
+		buf = make([]byte, sz)
+		if traceResp {
+			log.Printf("waiting for %d resp data", sz)
+		}
+		_, err = io.ReadFull(sq.transport, buf)
+		if err != nil {
+			return nil, fmt.Errorf("while reading from sqinn: %w", err)
+		}
+		if traceResp {
+			log.Printf("received %d bytes resp data: %v", len(buf), buf)
+			// time.Sleep(100 * time.Millisecond)
+		}
+		var kind byte
+		kind, buf = decodeByte(buf)
+		if kind == frameCallback {
+			sq.handleCallback(buf)
+			continue
+		}
+		var ok bool
+		ok, buf = decodeBool(buf)
+		if !ok {
+			msg, _ := decodeString(buf)
+			return nil, fmt.Errorf("sqinn: %s", msg)
+		}
+		return buf, nil
 	}
-	return buf, nil
 }
 
+// terminateGraceTimeout bounds how long Terminate waits for sqinn to
+// exit on its own, in response to the zero-length request below, before
+// falling back to a forceful Close.
+const terminateGraceTimeout = 5 * time.Second
+
 // Terminate terminates a running Sqinn instance.
 // Each Sqinn instance launched with New should be terminated
 // with Terminate. After Terminate has been called, this Sqinn
@@ -636,16 +717,13 @@ func (sq *Sqinn) Terminate() error {
 	sq.mx.Lock()
 	defer sq.mx.Unlock()
 	// a request of length zero makes sqinn terminate
-	_, err := sq.sin.Write(encodeInt32(0))
+	_, err := sq.transport.Write(encodeInt32(0))
 	if err != nil {
+		sq.transport.Close()
 		return err
 	}
-	err = sq.cmd.Wait()
-	if err != nil {
-		return err
+	if gc, ok := sq.transport.(gracefulCloser); ok {
+		return gc.CloseGraceful(terminateGraceTimeout)
 	}
-	sq.serr.Close()
-	sq.sout.Close()
-	sq.sin.Close()
-	return nil
-}
\ No newline at end of file
+	return sq.transport.Close()
+}
@@ -0,0 +1,19 @@
+package sqinn
+
+import "testing"
+
+// TestSqinnWithFakeTransport exercises Sqinn against a fake Transport
+// instead of a real sqinn subprocess, which Options.Transport exists to
+// make possible.
+func TestSqinnWithFakeTransport(t *testing.T) {
+	sq, err := New(Options{Transport: newFakeTransport()})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := sq.Open(":memory:"); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := sq.Terminate(); err != nil {
+		t.Fatalf("Terminate: %v", err)
+	}
+}
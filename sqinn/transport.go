@@ -0,0 +1,175 @@
+package sqinn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"time"
+)
+
+// Transport is the I/O channel Sqinn uses to exchange length-prefixed
+// request/response frames with a running sqinn. New uses StdioTransport
+// by default; set Options.Transport to use a different one, such as
+// NetTransport.
+type Transport interface {
+	// Write writes p to the transport, as Write does for io.Writer.
+	Write(p []byte) (int, error)
+	// Read reads into p, as Read does for io.Reader.
+	Read(p []byte) (int, error)
+	// Close closes the transport, aborting any request currently in
+	// flight.
+	Close() error
+}
+
+// gracefulCloser is implemented by Transports that can wait for a clean
+// shutdown before falling back to a forceful Close, such as
+// StdioTransport once Terminate's zero-length "please exit" request has
+// been sent. Terminate uses it when available instead of Close, so that
+// sqinn gets a chance to exit on its own instead of racing a kill signal
+// against its own shutdown handling.
+type gracefulCloser interface {
+	// CloseGraceful waits up to timeout for the transport to shut down on
+	// its own before falling back to the forceful behavior of Close.
+	CloseGraceful(timeout time.Duration) error
+}
+
+// StdioTransport launches a local sqinn subprocess and exchanges frames
+// over its stdin/stdout. It is the Transport New uses by default.
+type StdioTransport struct {
+	cmd  *exec.Cmd
+	sin  io.WriteCloser
+	sout io.ReadCloser
+	serr io.ReadCloser
+}
+
+// NewStdioTransport launches sqinnPath (or "sqinn" if empty) and returns
+// a Transport that talks to it over stdio. Whatever the subprocess
+// writes to stderr is logged through logger, which must not be nil.
+func NewStdioTransport(sqinnPath string, logger Logger) (*StdioTransport, error) {
+	if sqinnPath == "" {
+		sqinnPath = "sqinn"
+	}
+	cmd := exec.Command(sqinnPath)
+	sin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	sout, err := cmd.StdoutPipe()
+	if err != nil {
+		sin.Close()
+		return nil, err
+	}
+	serr, err := cmd.StderrPipe()
+	if err != nil {
+		sout.Close()
+		sin.Close()
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		serr.Close()
+		sout.Close()
+		sin.Close()
+		return nil, err
+	}
+	t := &StdioTransport{cmd, sin, sout, serr}
+	go t.logStderr(logger)
+	return t, nil
+}
+
+func (t *StdioTransport) logStderr(logger Logger) {
+	sc := bufio.NewScanner(t.serr)
+	for sc.Scan() {
+		text := sc.Text()
+		logger.Log(fmt.Sprintf("[sqinn] %s", text))
+	}
+	err := sc.Err()
+	if err != nil {
+		logger.Log(fmt.Sprintf("[sqinn] stderr: %s", err))
+	}
+}
+
+func (t *StdioTransport) Write(p []byte) (int, error) {
+	return t.sin.Write(p)
+}
+
+func (t *StdioTransport) Read(p []byte) (int, error) {
+	return t.sout.Read(p)
+}
+
+// Close kills the subprocess, waits for it to exit, and closes its
+// pipes. It is safe to call after the subprocess has already exited on
+// its own, e.g. in response to the zero-length request Terminate sends.
+func (t *StdioTransport) Close() error {
+	if t.cmd.Process != nil {
+		t.cmd.Process.Kill()
+	}
+	t.sin.Close()
+	t.sout.Close()
+	serrErr := t.serr.Close()
+	t.cmd.Wait()
+	return serrErr
+}
+
+// CloseGraceful waits up to timeout for the subprocess to exit on its
+// own before falling back to Close's forceful kill. It implements
+// gracefulCloser, for Terminate to use after sending the zero-length
+// "please exit" request so sqinn can shut down cleanly instead of
+// racing a kill signal against its own exit handling.
+func (t *StdioTransport) CloseGraceful(timeout time.Duration) error {
+	if t.cmd.Process == nil {
+		return t.Close()
+	}
+	exited := make(chan struct{})
+	go func() {
+		t.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(timeout):
+		t.cmd.Process.Kill()
+		<-exited
+	}
+	t.sin.Close()
+	t.sout.Close()
+	return t.serr.Close()
+}
+
+// NetTransport connects to an already-running sqinn daemon over TCP or a
+// Unix domain socket, instead of launching a local subprocess. This lets
+// a single sqinn process serve multiple Go processes, e.g. as a sidecar
+// container in Kubernetes or a shared read-only database across workers,
+// and lets a sandboxed or remote Go process that cannot spawn
+// subprocesses still talk to sqinn.
+type NetTransport struct {
+	conn net.Conn
+}
+
+// NewNetTransport dials a running sqinn daemon. network is "tcp" or
+// "unix"; address is a "host:port" for "tcp" or a socket path for
+// "unix", as accepted by net.Dial.
+func NewNetTransport(network, address string) (*NetTransport, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("sqinn: dial %s %s: %w", network, address, err)
+	}
+	return &NetTransport{conn: conn}, nil
+}
+
+func (t *NetTransport) Write(p []byte) (int, error) {
+	return t.conn.Write(p)
+}
+
+func (t *NetTransport) Read(p []byte) (int, error) {
+	return t.conn.Read(p)
+}
+
+// Close closes the connection to the sqinn daemon. Unlike
+// StdioTransport.Close, it does not terminate the daemon itself, since
+// other connections may still be using it.
+func (t *NetTransport) Close() error {
+	return t.conn.Close()
+}
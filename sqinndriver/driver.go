@@ -0,0 +1,488 @@
+// Package sqinndriver implements Go's database/sql/driver interfaces on
+// top of sqinn.Sqinn, so sqinn-go can be plugged into any code written
+// against database/sql (including ORMs such as sqlx or gorm) the same
+// way mattn/go-sqlite3 or modernc.org/sqlite can.
+//
+// Register it with database/sql by blank-importing this package:
+//
+//     import _ "github.com/v786/sqinn-go/sqinndriver"
+//
+//     db, err := sql.Open("sqinn", "/tmp/test.db")
+//
+// The dsn passed to sql.Open is the sqlite filename (":memory:" or a
+// filesystem path). The path to the sqinn executable can be given with a
+// "sqinn" query parameter, e.g. "/tmp/test.db?sqinn=/usr/local/bin/sqinn".
+//
+// Each *sql.DB connection in the pool spawns its own sqinn subprocess
+// (see Connector.Connect), since sqinn allows only one open database and
+// one active prepared statement per instance.
+package sqinndriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/v786/sqinn-go/sqinn"
+)
+
+func init() {
+	sql.Register("sqinn", &Driver{})
+}
+
+// Driver implements driver.Driver and driver.DriverContext.
+type Driver struct{}
+
+var (
+	_ driver.Driver        = (*Driver)(nil)
+	_ driver.DriverContext = (*Driver)(nil)
+)
+
+// Open opens a new connection using the legacy driver.Driver interface.
+// Callers going through database/sql normally never hit this path;
+// sql.DB uses OpenConnector instead.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector parses dsn and returns a Connector that spawns one Sqinn
+// subprocess per Conn.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	filename, options, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Connector{driver: d, filename: filename, options: options}, nil
+}
+
+func parseDSN(dsn string) (string, sqinn.Options, error) {
+	var options sqinn.Options
+	filename := dsn
+	if i := strings.IndexByte(dsn, '?'); i >= 0 {
+		filename = dsn[:i]
+		query, err := url.ParseQuery(dsn[i+1:])
+		if err != nil {
+			return "", options, fmt.Errorf("sqinndriver: invalid dsn %q: %w", dsn, err)
+		}
+		options.SqinnPath = query.Get("sqinn")
+	}
+	if filename == "" {
+		return "", options, fmt.Errorf("sqinndriver: dsn must name a database file or \":memory:\"")
+	}
+	return filename, options, nil
+}
+
+// Connector implements driver.Connector. Each call to Connect spawns a
+// new Sqinn subprocess with its own open database.
+type Connector struct {
+	driver   *Driver
+	filename string
+	options  sqinn.Options
+}
+
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	sq, err := sqinn.New(c.options)
+	if err != nil {
+		return nil, fmt.Errorf("sqinndriver: launch sqinn: %w", err)
+	}
+	if err := sq.OpenContext(ctx, c.filename); err != nil {
+		sq.Terminate()
+		return nil, fmt.Errorf("sqinndriver: open %q: %w", c.filename, err)
+	}
+	return &Conn{sq: sq}, nil
+}
+
+func (c *Connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// toDriverErr translates an error from a *Context call into
+// driver.ErrBadConn when it means ctx aborted the request: that poisons
+// the underlying Sqinn (see sqinn's withContext doc), so the Conn
+// wrapping it is no longer usable and database/sql must discard it
+// instead of returning it to its connection pool.
+func toDriverErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return driver.ErrBadConn
+	}
+	return err
+}
+
+// Conn implements driver.Conn backed by a single Sqinn subprocess. Since
+// sqinn allows only one prepared statement to be active at a time, Conn
+// refuses to prepare a new Stmt while a previous one is still open.
+type Conn struct {
+	sq       *sqinn.Sqinn
+	closed   bool
+	stmtOpen bool
+}
+
+var (
+	_ driver.Conn               = (*Conn)(nil)
+	_ driver.ConnPrepareContext = (*Conn)(nil)
+	_ driver.ExecerContext      = (*Conn)(nil)
+	_ driver.ConnBeginTx        = (*Conn)(nil)
+	_ driver.Pinger             = (*Conn)(nil)
+	_ driver.NamedValueChecker  = (*Conn)(nil)
+)
+
+func (c *Conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.stmtOpen {
+		return nil, fmt.Errorf("sqinndriver: a statement is already active on this connection")
+	}
+	if err := c.sq.PrepareContext(ctx, query); err != nil {
+		return nil, toDriverErr(err)
+	}
+	c.stmtOpen = true
+	return &Stmt{conn: c}, nil
+}
+
+func (c *Conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	closeErr := c.sq.Close()
+	if err := c.sq.Terminate(); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (c *Conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		return nil, fmt.Errorf("sqinndriver: read-only transactions are not supported")
+	}
+	if _, err := c.sq.ExecOneContext(ctx, "BEGIN TRANSACTION"); err != nil {
+		return nil, toDriverErr(err)
+	}
+	return &Tx{conn: c}, nil
+}
+
+func (c *Conn) Ping(ctx context.Context) error {
+	_, err := c.sq.IoVersionContext(ctx)
+	return toDriverErr(err)
+}
+
+func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := c.sq.ExecContext(ctx, query, 1, len(values), values)
+	if err != nil {
+		return nil, toDriverErr(err)
+	}
+	return &Result{rowsAffected: int64(changes[0])}, nil
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, restricting bind
+// values to the types Sqinn.Bind understands (int64, float64, string,
+// []byte, nil) and converting compatible Go types into them.
+func (c *Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch v := nv.Value.(type) {
+	case nil, int64, float64, string, []byte:
+		return nil
+	case bool:
+		if v {
+			nv.Value = int64(1)
+		} else {
+			nv.Value = int64(0)
+		}
+	case int:
+		nv.Value = int64(v)
+	case int32:
+		nv.Value = int64(v)
+	case float32:
+		nv.Value = float64(v)
+	default:
+		return fmt.Errorf("sqinndriver: cannot bind value of type %T", v)
+	}
+	return nil
+}
+
+// Tx implements driver.Tx via plain BEGIN/COMMIT/ROLLBACK ExecOne calls,
+// since sqinn has no dedicated transaction opcodes.
+type Tx struct {
+	conn *Conn
+}
+
+var _ driver.Tx = (*Tx)(nil)
+
+func (tx *Tx) Commit() error {
+	_, err := tx.conn.sq.ExecOne("COMMIT")
+	return err
+}
+
+func (tx *Tx) Rollback() error {
+	_, err := tx.conn.sq.ExecOne("ROLLBACK")
+	return err
+}
+
+// Result implements driver.Result. sqinn does not report the last
+// inserted row id without an extra query, so LastInsertId always
+// returns an error; callers who need it should query
+// "SELECT last_insert_rowid()" explicitly.
+type Result struct {
+	rowsAffected int64
+}
+
+var _ driver.Result = (*Result)(nil)
+
+func (r *Result) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("sqinndriver: LastInsertId is not supported, use \"SELECT last_insert_rowid()\"")
+}
+
+func (r *Result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+// Stmt implements driver.Stmt. Since sqinn allows only one prepared
+// statement per instance, Close must be called (which finalizes the
+// statement) before another statement can be prepared on the same Conn.
+type Stmt struct {
+	conn   *Conn
+	closed bool
+}
+
+var (
+	_ driver.Stmt             = (*Stmt)(nil)
+	_ driver.StmtExecContext  = (*Stmt)(nil)
+	_ driver.StmtQueryContext = (*Stmt)(nil)
+)
+
+func (s *Stmt) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.conn.stmtOpen = false
+	return s.conn.sq.Finalize()
+}
+
+// NumInput reports that the parameter count is unknown: sqinn does not
+// expose sqlite3_bind_parameter_count, so database/sql should skip its
+// own arity check and let Bind fail instead.
+func (s *Stmt) NumInput() int {
+	return -1
+}
+
+func (s *Stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valuesToNamedValues(args))
+}
+
+func (s *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.bind(ctx, args); err != nil {
+		return nil, toDriverErr(err)
+	}
+	if _, err := s.conn.sq.StepContext(ctx); err != nil {
+		return nil, toDriverErr(err)
+	}
+	changes, err := s.conn.sq.ChangesContext(ctx)
+	if err != nil {
+		return nil, toDriverErr(err)
+	}
+	if err := s.conn.sq.ResetContext(ctx); err != nil {
+		return nil, toDriverErr(err)
+	}
+	return &Result{rowsAffected: int64(changes)}, nil
+}
+
+func (s *Stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valuesToNamedValues(args))
+}
+
+func (s *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.bind(ctx, args); err != nil {
+		return nil, toDriverErr(err)
+	}
+	ncols, names, err := s.columnInfo(ctx)
+	if err != nil {
+		return nil, toDriverErr(err)
+	}
+	return &Rows{stmt: s, ctx: ctx, ncols: ncols, names: names, colTypes: make([]byte, ncols)}, nil
+}
+
+func (s *Stmt) bind(ctx context.Context, args []driver.NamedValue) error {
+	for _, arg := range args {
+		if err := s.conn.sq.BindContext(ctx, arg.Ordinal, arg.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stmt) columnInfo(ctx context.Context) (int, []string, error) {
+	ncols, err := s.conn.sq.ColumnCountContext(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	names := make([]string, ncols)
+	for icol := 0; icol < ncols; icol++ {
+		names[icol], err = s.conn.sq.ColumnNameContext(ctx, icol)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return ncols, names, nil
+}
+
+// Rows implements driver.Rows. Rows are stepped one row at a time using
+// the low-level Prepare/Bind/Step/Column/Finalize cycle, so results are
+// streamed from sqinn rather than buffered up front like Sqinn.Query
+// does.
+type Rows struct {
+	stmt     *Stmt
+	ctx      context.Context
+	ncols    int
+	names    []string
+	colTypes []byte
+	done     bool
+}
+
+var (
+	_ driver.Rows                           = (*Rows)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*Rows)(nil)
+	_ driver.RowsColumnTypeNullable         = (*Rows)(nil)
+)
+
+func (r *Rows) Columns() []string {
+	return r.names
+}
+
+// close marks Rows done and resets the underlying prepared statement so
+// it can be bound and stepped again for a later Query call on the same
+// Stmt. It is idempotent: only the first call actually resets.
+func (r *Rows) close() error {
+	if r.done {
+		return nil
+	}
+	r.done = true
+	return toDriverErr(r.stmt.conn.sq.ResetContext(r.ctx))
+}
+
+func (r *Rows) Close() error {
+	return r.close()
+}
+
+func (r *Rows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	more, err := r.stmt.conn.sq.StepContext(r.ctx)
+	if err != nil {
+		return toDriverErr(err)
+	}
+	if !more {
+		if err := r.close(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for icol := 0; icol < r.ncols; icol++ {
+		colType, err := r.stmt.conn.sq.ColumnTypeContext(r.ctx, icol)
+		if err != nil {
+			return toDriverErr(err)
+		}
+		r.colTypes[icol] = colType
+		any, err := r.stmt.conn.sq.ColumnContext(r.ctx, icol, colType)
+		if err != nil {
+			return toDriverErr(err)
+		}
+		dest[icol] = anyValueToDriverValue(any, colType)
+	}
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName reports the SQLite storage class observed
+// for this column in the most recently stepped row. Since SQLite columns
+// are dynamically typed, this may change between rows.
+func (r *Rows) ColumnTypeDatabaseTypeName(index int) string {
+	return sqliteTypeName(r.colTypes[index])
+}
+
+// ColumnTypeNullable always reports (true, true): sqinn does not expose
+// a column's NOT NULL constraint ahead of reading its value.
+func (r *Rows) ColumnTypeNullable(index int) (bool, bool) {
+	return true, true
+}
+
+func namedValuesToValues(args []driver.NamedValue) ([]interface{}, error) {
+	values := make([]interface{}, len(args))
+	for _, arg := range args {
+		if arg.Ordinal < 1 || arg.Ordinal > len(args) {
+			return nil, fmt.Errorf("sqinndriver: bind ordinal %d out of range", arg.Ordinal)
+		}
+		values[arg.Ordinal-1] = arg.Value
+	}
+	return values, nil
+}
+
+func valuesToNamedValues(args []driver.Value) []driver.NamedValue {
+	nv := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nv[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nv
+}
+
+func anyValueToDriverValue(any sqinn.AnyValue, colType byte) driver.Value {
+	switch colType {
+	case sqinn.ValInt:
+		if any.Int.Set {
+			return int64(any.Int.Value)
+		}
+	case sqinn.ValInt64:
+		if any.Int64.Set {
+			return any.Int64.Value
+		}
+	case sqinn.ValDouble:
+		if any.Double.Set {
+			return any.Double.Value
+		}
+	case sqinn.ValText:
+		if any.String.Set {
+			return any.String.Value
+		}
+	case sqinn.ValBlob:
+		if any.Blob.Set {
+			return any.Blob.Value
+		}
+	}
+	return nil
+}
+
+func sqliteTypeName(colType byte) string {
+	switch colType {
+	case sqinn.ValInt, sqinn.ValInt64:
+		return "INTEGER"
+	case sqinn.ValDouble:
+		return "REAL"
+	case sqinn.ValText:
+		return "TEXT"
+	case sqinn.ValBlob:
+		return "BLOB"
+	default:
+		return ""
+	}
+}